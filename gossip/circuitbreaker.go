@@ -0,0 +1,116 @@
+package gossip
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrBreakerOpen is returned (wrapped) by callers that decline to dial a
+// peer because its CircuitBreaker is currently open, so errors.Is can tell
+// "this node is known down" apart from a genuine request failure. readShard's
+// hedging does not make that distinction: any failure of the primary,
+// this one included, dispatches the secondary at once.
+var ErrBreakerOpen = errors.New("gossip: circuit breaker open")
+
+// CircuitBreakerState is the state of a per-peer CircuitBreaker.
+type CircuitBreakerState int
+
+// Breaker states, in the usual closed/open/half-open cycle.
+const (
+	CircuitClosed CircuitBreakerState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+// String returns the state's name, for logging and metrics labels.
+func (s CircuitBreakerState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// CircuitBreaker fences off a peer that has failed Threshold times in a
+// row: once open it fails fast for Cooldown, then lets exactly one probe
+// request through (half-open) to decide whether to close again.
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    CircuitBreakerState
+	failures int
+	openedAt time.Time
+}
+
+// NewCircuitBreaker returns a closed CircuitBreaker that opens after
+// threshold consecutive failures and cools down for the given duration.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{Threshold: threshold, Cooldown: cooldown}
+}
+
+// Allow reports whether a request should be attempted: always true when
+// closed, never when open, and true for exactly one caller once Cooldown
+// has elapsed (the half-open probe).
+func (cb *CircuitBreaker) Allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case CircuitClosed:
+		return true
+	case CircuitHalfOpen:
+		return false
+	default: // CircuitOpen
+		if time.Since(cb.openedAt) < cb.Cooldown {
+			return false
+		}
+		cb.state = CircuitHalfOpen
+		return true
+	}
+}
+
+// RecordSuccess closes the breaker and resets its failure count.
+func (cb *CircuitBreaker) RecordSuccess() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.state = CircuitClosed
+	cb.failures = 0
+}
+
+// RecordFailure counts a failure, opening the breaker once Threshold
+// consecutive failures have been seen. A failed half-open probe reopens
+// the breaker immediately regardless of Threshold.
+func (cb *CircuitBreaker) RecordFailure() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == CircuitHalfOpen {
+		cb.open()
+		return
+	}
+
+	cb.failures++
+	if cb.failures >= cb.Threshold {
+		cb.open()
+	}
+}
+
+func (cb *CircuitBreaker) open() {
+	cb.state = CircuitOpen
+	cb.openedAt = time.Now()
+	cb.failures = 0
+}
+
+// State reports the breaker's current state, for the metrics interface
+// operators use to see which peers are currently fenced off.
+func (cb *CircuitBreaker) State() CircuitBreakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
@@ -0,0 +1,83 @@
+package gossip
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker_OpensAfterThresholdFailures(t *testing.T) {
+	cb := NewCircuitBreaker(3, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		if !cb.Allow() {
+			t.Fatalf("failure %d: breaker should still be closed", i)
+		}
+		cb.RecordFailure()
+	}
+	if cb.State() != CircuitClosed {
+		t.Fatalf("breaker should still be closed after 2 of 3 failures, got %v", cb.State())
+	}
+
+	cb.RecordFailure()
+	if cb.State() != CircuitOpen {
+		t.Fatalf("breaker should be open after reaching threshold, got %v", cb.State())
+	}
+	if cb.Allow() {
+		t.Fatal("an open breaker should not allow requests before Cooldown elapses")
+	}
+}
+
+func TestCircuitBreaker_HalfOpenProbeClosesOnSuccess(t *testing.T) {
+	cb := NewCircuitBreaker(1, 10*time.Millisecond)
+
+	cb.Allow()
+	cb.RecordFailure() // opens
+
+	time.Sleep(15 * time.Millisecond)
+
+	if !cb.Allow() {
+		t.Fatal("breaker should allow exactly one half-open probe after Cooldown")
+	}
+	if cb.Allow() {
+		t.Fatal("a second concurrent caller should not get another half-open probe")
+	}
+
+	cb.RecordSuccess()
+	if cb.State() != CircuitClosed {
+		t.Fatalf("a successful half-open probe should close the breaker, got %v", cb.State())
+	}
+	if !cb.Allow() {
+		t.Fatal("breaker should allow requests again once closed")
+	}
+}
+
+func TestCircuitBreaker_HalfOpenProbeReopensOnFailure(t *testing.T) {
+	cb := NewCircuitBreaker(1, 10*time.Millisecond)
+
+	cb.Allow()
+	cb.RecordFailure() // opens
+
+	time.Sleep(15 * time.Millisecond)
+	if !cb.Allow() {
+		t.Fatal("breaker should allow the half-open probe after Cooldown")
+	}
+
+	cb.RecordFailure()
+	if cb.State() != CircuitOpen {
+		t.Fatalf("a failed half-open probe should reopen the breaker, got %v", cb.State())
+	}
+	if cb.Allow() {
+		t.Fatal("breaker should not allow requests immediately after reopening")
+	}
+}
+
+func TestCircuitBreaker_SuccessResetsFailureCount(t *testing.T) {
+	cb := NewCircuitBreaker(2, time.Minute)
+
+	cb.RecordFailure()
+	cb.RecordSuccess()
+	cb.RecordFailure()
+	if cb.State() != CircuitClosed {
+		t.Fatalf("a single failure after a reset shouldn't open the breaker, got %v", cb.State())
+	}
+}
@@ -0,0 +1,123 @@
+package gossip
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// ewmaDecay weights each new latency sample against the running average.
+// Higher values react faster to a peer's recent behavior.
+const ewmaDecay = 0.2
+
+// DefaultHedgeDelay is how long RemoteIteratorCreator waits for the primary
+// replica to answer before also dispatching to a secondary.
+const DefaultHedgeDelay = 50 * time.Millisecond
+
+// PeerStats tracks a single node's recent responsiveness so the
+// Coordinator can prefer faster, less-loaded replicas when a shard has
+// more than one copy.
+type PeerStats struct {
+	mu       sync.Mutex
+	latency  float64 // EWMA of observed round trips, in seconds
+	inflight int
+}
+
+// Observe folds a completed round trip of duration d into the EWMA.
+func (ps *PeerStats) Observe(d time.Duration) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	sample := d.Seconds()
+	if ps.latency == 0 {
+		ps.latency = sample
+		return
+	}
+	ps.latency = ewmaDecay*sample + (1-ewmaDecay)*ps.latency
+}
+
+func (ps *PeerStats) startRequest() {
+	ps.mu.Lock()
+	ps.inflight++
+	ps.mu.Unlock()
+}
+
+func (ps *PeerStats) endRequest() {
+	ps.mu.Lock()
+	ps.inflight--
+	ps.mu.Unlock()
+}
+
+func (ps *PeerStats) score() (latency float64, inflight int) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.latency, ps.inflight
+}
+
+// Coordinator owns the latency/load picture of the cluster's nodes and
+// decides, for a shard with several replicas, which node to try first and
+// which to hedge against.
+type Coordinator struct {
+	mu         sync.Mutex
+	peers      map[uint64]*PeerStats
+	HedgeDelay time.Duration
+}
+
+// NewCoordinator returns a Coordinator that hedges after delay.
+func NewCoordinator(delay time.Duration) *Coordinator {
+	return &Coordinator{
+		peers:      make(map[uint64]*PeerStats),
+		HedgeDelay: delay,
+	}
+}
+
+// DefaultCoordinator is the process-wide Coordinator used by
+// RemoteIteratorCreator when a shard has multiple replicas.
+var DefaultCoordinator = NewCoordinator(DefaultHedgeDelay)
+
+// peerStats returns (creating if necessary) the PeerStats tracked for
+// nodeID.
+func (c *Coordinator) peerStats(nodeID uint64) *PeerStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ps, ok := c.peers[nodeID]
+	if !ok {
+		ps = &PeerStats{}
+		c.peers[nodeID] = ps
+	}
+	return ps
+}
+
+// Rank sorts nodeIDs by ascending (suspect, latency, in-flight requests),
+// cheapest first, so the caller can try the most promising replica before
+// hedging to the next. Nodes DefaultMembership currently has marked
+// suspect sort after every non-suspect node, regardless of latency, since a
+// locally-observed connection failure is a stronger signal than a stale
+// EWMA.
+func (c *Coordinator) Rank(nodeIDs []uint64) []uint64 {
+	ranked := make([]uint64, len(nodeIDs))
+	copy(ranked, nodeIDs)
+
+	latency := make(map[uint64]float64, len(ranked))
+	inflight := make(map[uint64]int, len(ranked))
+	suspect := make(map[uint64]bool, len(ranked))
+	for _, id := range ranked {
+		l, n := c.peerStats(id).score()
+		latency[id] = l
+		inflight[id] = n
+		suspect[id] = DefaultMembership.IsSuspect(id)
+	}
+
+	sort.Slice(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if suspect[a] != suspect[b] {
+			return !suspect[a]
+		}
+		if latency[a] != latency[b] {
+			return latency[a] < latency[b]
+		}
+		return inflight[a] < inflight[b]
+	})
+	return ranked
+}
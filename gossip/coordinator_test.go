@@ -0,0 +1,40 @@
+package gossip
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCoordinator_RankOrdersByLatencyThenInflight(t *testing.T) {
+	c := NewCoordinator(50 * time.Millisecond)
+
+	const slow, fast, fastButBusy = uint64(91001), uint64(91002), uint64(91003)
+	c.peerStats(slow).Observe(30 * time.Millisecond)
+	c.peerStats(fast).Observe(10 * time.Millisecond)
+	c.peerStats(fastButBusy).Observe(10 * time.Millisecond)
+	c.peerStats(fastButBusy).startRequest() // an extra in-flight request breaks the latency tie
+
+	ranked := c.Rank([]uint64{slow, fast, fastButBusy})
+	want := []uint64{fast, fastButBusy, slow}
+	for i, id := range want {
+		if ranked[i] != id {
+			t.Fatalf("ranked = %v, want %v", ranked, want)
+		}
+	}
+}
+
+func TestCoordinator_RankSortsSuspectNodesLastRegardlessOfLatency(t *testing.T) {
+	c := NewCoordinator(50 * time.Millisecond)
+
+	const fastButSuspect, slowButHealthy = uint64(91004), uint64(91005)
+	DefaultMembership.nodes[fastButSuspect] = &nodeEntry{info: NodesList{ID: fastButSuspect}, state: StateAlive}
+	DefaultMembership.MarkSuspect(fastButSuspect)
+
+	c.peerStats(fastButSuspect).Observe(time.Millisecond)
+	c.peerStats(slowButHealthy).Observe(time.Second)
+
+	ranked := c.Rank([]uint64{fastButSuspect, slowButHealthy})
+	if ranked[0] != slowButHealthy || ranked[1] != fastButSuspect {
+		t.Fatalf("ranked = %v, want the healthy node ranked ahead of the suspect one despite being slower", ranked)
+	}
+}
@@ -0,0 +1,344 @@
+package gossip
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// NodeState is a node's membership state as seen by this process. It never
+// reflects a consensus view of the cluster, only what this node has
+// observed via CFLUX_ENDPOINT polls and gossip.
+type NodeState int
+
+// Membership states, in the usual SWIM order.
+const (
+	StateAlive NodeState = iota
+	StateSuspect
+	StateDead
+)
+
+// MembershipEvent describes a state transition for a single node,
+// delivered to Subscribe()'d channels and exchanged over the gossip port.
+type MembershipEvent struct {
+	NodeID      uint64    `json:"nodeId"`
+	State       NodeState `json:"state"`
+	Incarnation uint64    `json:"incarnation"`
+}
+
+type nodeEntry struct {
+	info        NodesList
+	state       NodeState
+	incarnation uint64
+	suspectAt   time.Time
+}
+
+// GossipPort is the UDP port nodes use to exchange membership state
+// directly with one another, independent of the CFLUX_ENDPOINT poll.
+const GossipPort = 7950
+
+// Membership maintains a locally cached view of cluster membership so that
+// RemoteIteratorCreator no longer needs to hit CFLUX_ENDPOINT on every
+// CreateIterator/FieldDimensions/ExpandSources call. The cache is kept
+// current by a background ticker poll plus a UDP gossip channel that lets
+// nodes piggyback alive/suspect/dead state onto each other directly, the
+// same incarnation-numbered SWIM scheme used to avoid stale gossip
+// clobbering a newer observation.
+type Membership struct {
+	mu    sync.RWMutex
+	nodes map[uint64]*nodeEntry
+
+	subMu sync.Mutex
+	subs  []chan MembershipEvent
+
+	refreshMu      sync.Mutex
+	refreshPending chan struct{}
+	refreshErr     error
+
+	// refreshFunc performs the actual CFLUX_ENDPOINT fetch that refresh
+	// coalesces concurrent callers onto. It defaults to m.doRefresh; tests
+	// override it to exercise the coalescing logic without a real network
+	// call.
+	refreshFunc func() error
+
+	// RefreshInterval is how often the background ticker polls
+	// CFLUX_ENDPOINT. SuspicionTimeout is how long a node stays biased away
+	// from once marked suspect, absent a gossip heartbeat clearing it sooner.
+	RefreshInterval  time.Duration
+	SuspicionTimeout time.Duration
+
+	stop chan struct{}
+}
+
+// NewMembership returns a Membership with the given poll interval and
+// suspicion timeout. Call Start to begin the background refresh ticker.
+func NewMembership(refreshInterval, suspicionTimeout time.Duration) *Membership {
+	m := &Membership{
+		nodes:            make(map[uint64]*nodeEntry),
+		RefreshInterval:  refreshInterval,
+		SuspicionTimeout: suspicionTimeout,
+		stop:             make(chan struct{}),
+	}
+	m.refreshFunc = m.doRefresh
+	return m
+}
+
+// DefaultMembership is the process-wide Membership cache consulted by
+// AliveNodesMap and the shard-read hot path.
+var DefaultMembership = NewMembership(10*time.Second, 30*time.Second)
+
+// Start launches the background refresh ticker in its own goroutine.
+func (m *Membership) Start() {
+	go func() {
+		ticker := time.NewTicker(m.RefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := m.refresh(); err != nil {
+					log.Printf("Membership: background refresh failed: %s", err.Error())
+				}
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop halts the background refresh ticker and any running ListenGossip.
+func (m *Membership) Stop() {
+	close(m.stop)
+}
+
+// LookupNode returns the cached info for id, refreshing synchronously on a
+// cache miss.
+func (m *Membership) LookupNode(id uint64) (NodesList, bool) {
+	m.mu.RLock()
+	entry, ok := m.nodes[id]
+	m.mu.RUnlock()
+	if ok {
+		return entry.info, true
+	}
+
+	if err := m.refresh(); err != nil {
+		return NodesList{}, false
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entry, ok = m.nodes[id]
+	if !ok {
+		return NodesList{}, false
+	}
+	return entry.info, true
+}
+
+// Snapshot returns every currently known node keyed by ID, refreshing
+// synchronously if the cache hasn't been populated yet. This is what
+// AliveNodesMap now delegates to instead of issuing its own GET.
+func (m *Membership) Snapshot() (map[uint64]NodesList, error) {
+	m.mu.RLock()
+	empty := len(m.nodes) == 0
+	m.mu.RUnlock()
+	if empty {
+		if err := m.refresh(); err != nil {
+			return nil, err
+		}
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make(map[uint64]NodesList, len(m.nodes))
+	for id, entry := range m.nodes {
+		out[id] = entry.info
+	}
+	return out, nil
+}
+
+// Subscribe returns a buffered channel of membership state-change events.
+// A slow subscriber misses events rather than blocking publication.
+func (m *Membership) Subscribe() <-chan MembershipEvent {
+	ch := make(chan MembershipEvent, 16)
+	m.subMu.Lock()
+	m.subs = append(m.subs, ch)
+	m.subMu.Unlock()
+	return ch
+}
+
+func (m *Membership) publish(ev MembershipEvent) {
+	m.subMu.Lock()
+	defer m.subMu.Unlock()
+	for _, ch := range m.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// MarkSuspect records a local suspicion that id is unreachable, bumping its
+// incarnation number and biasing IsSuspect/Rank away from it until
+// SuspicionTimeout elapses or a refresh/gossip heartbeat clears the state.
+func (m *Membership) MarkSuspect(id uint64) {
+	m.mu.Lock()
+	entry, ok := m.nodes[id]
+	if !ok || entry.state == StateSuspect {
+		m.mu.Unlock()
+		return
+	}
+	entry.state = StateSuspect
+	entry.suspectAt = time.Now()
+	entry.incarnation++
+	ev := MembershipEvent{NodeID: id, State: StateSuspect, Incarnation: entry.incarnation}
+	m.mu.Unlock()
+
+	m.publish(ev)
+}
+
+// IsSuspect reports whether id is currently marked suspect and the
+// suspicion hasn't yet timed out.
+func (m *Membership) IsSuspect(id uint64) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entry, ok := m.nodes[id]
+	if !ok || entry.state != StateSuspect {
+		return false
+	}
+	return time.Since(entry.suspectAt) < m.SuspicionTimeout
+}
+
+// refresh fetches the current node list from CFLUX_ENDPOINT and merges it
+// into the cache. Concurrent callers coalesce onto a single in-flight GET
+// rather than each issuing their own, and all of them observe that GET's
+// actual result: a coalesced caller must see the same error the caller
+// driving the fetch does, not a false nil that would let Snapshot hand back
+// a stale or empty map during a real CFLUX_ENDPOINT outage.
+func (m *Membership) refresh() error {
+	m.refreshMu.Lock()
+	if m.refreshPending != nil {
+		wait := m.refreshPending
+		m.refreshMu.Unlock()
+		<-wait
+		m.refreshMu.Lock()
+		err := m.refreshErr
+		m.refreshMu.Unlock()
+		return err
+	}
+	done := make(chan struct{})
+	m.refreshPending = done
+	m.refreshMu.Unlock()
+
+	err := m.refreshFunc()
+
+	m.refreshMu.Lock()
+	m.refreshErr = err
+	m.refreshPending = nil
+	m.refreshMu.Unlock()
+	close(done)
+
+	return err
+}
+
+func (m *Membership) doRefresh() error {
+	f := func() (*http.Request, error) {
+		u := viper.GetString("CFLUX_ENDPOINT") + "/nodes/" + url.QueryEscape(viper.GetString("CLUSTER"))
+		return http.NewRequest("GET", u, nil)
+	}
+	resp, err := ExpBackoffRequest(f)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var nodeList []NodesList
+	if err := json.NewDecoder(resp.Body).Decode(&nodeList); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, node := range nodeList {
+		entry, ok := m.nodes[node.ID]
+		if !ok {
+			m.nodes[node.ID] = &nodeEntry{info: node, state: StateAlive}
+			continue
+		}
+		entry.info = node
+		if entry.state == StateSuspect {
+			entry.state = StateAlive
+			m.publish(MembershipEvent{NodeID: node.ID, State: StateAlive, Incarnation: entry.incarnation})
+		}
+	}
+	return nil
+}
+
+// ListenGossip runs a UDP listener on GossipPort, applying any
+// alive/suspect/dead events it receives to the cache. It blocks until Stop
+// is called, so run it in its own goroutine.
+func (m *Membership) ListenGossip() error {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: GossipPort})
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-m.stop
+		conn.Close()
+	}()
+
+	buf := make([]byte, 512)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return nil
+		}
+		var ev MembershipEvent
+		if err := json.Unmarshal(buf[:n], &ev); err != nil {
+			continue
+		}
+		m.applyGossip(ev)
+	}
+}
+
+// GossipTo sends ev to peer's gossip listener, piggybacking ev's
+// incarnation number so the receiver can discard it if it has already
+// observed a later incarnation for that node.
+func (m *Membership) GossipTo(peer string, ev MembershipEvent) error {
+	conn, err := net.Dial("udp", peer)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(body)
+	return err
+}
+
+func (m *Membership) applyGossip(ev MembershipEvent) {
+	m.mu.Lock()
+	entry, ok := m.nodes[ev.NodeID]
+	if !ok || ev.Incarnation < entry.incarnation {
+		m.mu.Unlock()
+		return
+	}
+	entry.incarnation = ev.Incarnation
+	changed := entry.state != ev.State
+	entry.state = ev.State
+	if ev.State == StateSuspect {
+		entry.suspectAt = time.Now()
+	}
+	m.mu.Unlock()
+
+	if changed {
+		m.publish(ev)
+	}
+}
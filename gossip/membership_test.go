@@ -0,0 +1,150 @@
+package gossip
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMembership_MarkSuspectAndTimeout(t *testing.T) {
+	m := NewMembership(time.Minute, 20*time.Millisecond)
+	m.nodes[1] = &nodeEntry{info: NodesList{ID: 1}, state: StateAlive}
+
+	if m.IsSuspect(1) {
+		t.Fatal("node should not be suspect before MarkSuspect")
+	}
+
+	m.MarkSuspect(1)
+	if !m.IsSuspect(1) {
+		t.Fatal("node should be suspect immediately after MarkSuspect")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if m.IsSuspect(1) {
+		t.Fatal("suspicion should have timed out")
+	}
+}
+
+func TestMembership_MarkSuspectBumpsIncarnationOnce(t *testing.T) {
+	m := NewMembership(time.Minute, time.Minute)
+	m.nodes[1] = &nodeEntry{info: NodesList{ID: 1}, state: StateAlive}
+
+	m.MarkSuspect(1)
+	first := m.nodes[1].incarnation
+
+	// A node already marked suspect shouldn't bump its incarnation again on
+	// a second, redundant MarkSuspect call.
+	m.MarkSuspect(1)
+	if m.nodes[1].incarnation != first {
+		t.Fatalf("incarnation changed on redundant MarkSuspect: got %d, want %d", m.nodes[1].incarnation, first)
+	}
+}
+
+func TestMembership_ApplyGossipIgnoresStaleIncarnation(t *testing.T) {
+	m := NewMembership(time.Minute, time.Minute)
+	m.nodes[1] = &nodeEntry{info: NodesList{ID: 1}, state: StateAlive, incarnation: 5}
+
+	m.applyGossip(MembershipEvent{NodeID: 1, State: StateSuspect, Incarnation: 3})
+
+	if m.nodes[1].state != StateAlive {
+		t.Fatalf("stale gossip event should not have changed state, got %v", m.nodes[1].state)
+	}
+}
+
+func TestMembership_ApplyGossipHealsPartition(t *testing.T) {
+	m := NewMembership(time.Minute, time.Minute)
+	m.nodes[1] = &nodeEntry{info: NodesList{ID: 1}, state: StateAlive}
+
+	// Simulate this node losing contact with node 1 during a partition.
+	m.MarkSuspect(1)
+	if !m.IsSuspect(1) {
+		t.Fatal("node should be suspect after MarkSuspect")
+	}
+
+	sub := m.Subscribe()
+
+	// A later-incarnation gossip event from a peer that can still reach
+	// node 1 should clear the suspicion.
+	m.applyGossip(MembershipEvent{NodeID: 1, State: StateAlive, Incarnation: m.nodes[1].incarnation + 1})
+
+	if m.IsSuspect(1) {
+		t.Fatal("gossip heartbeat should have cleared suspicion")
+	}
+
+	select {
+	case ev := <-sub:
+		if ev.State != StateAlive {
+			t.Fatalf("expected StateAlive event, got %v", ev.State)
+		}
+	default:
+		t.Fatal("expected a published event for the state change")
+	}
+}
+
+func TestMembership_RefreshCoalescesConcurrentCallers(t *testing.T) {
+	m := NewMembership(time.Minute, time.Minute)
+
+	var calls int32
+	release := make(chan struct{})
+	m.refreshFunc = func() error {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return nil
+	}
+
+	const n = 10
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			m.refresh()
+		}()
+	}
+
+	// Give every goroutine a chance to reach refresh() and coalesce onto
+	// the single in-flight call before it's allowed to complete.
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("refreshFunc called %d times, want 1", got)
+	}
+}
+
+func TestMembership_RefreshPropagatesErrorToCoalescedCallers(t *testing.T) {
+	m := NewMembership(time.Minute, time.Minute)
+
+	wantErr := errors.New("CFLUX_ENDPOINT unreachable")
+	release := make(chan struct{})
+	m.refreshFunc = func() error {
+		<-release
+		return wantErr
+	}
+
+	const n = 10
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = m.refresh()
+		}(i)
+	}
+
+	// Give every goroutine a chance to reach refresh() and coalesce onto
+	// the single in-flight call before it's allowed to fail.
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != wantErr {
+			t.Fatalf("caller %d: refresh() returned %v, want %v", i, err, wantErr)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package gossip
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+
+	"github.com/influxdata/influxdb/gossip/transport"
+	"github.com/influxdata/influxdb/influxql"
+)
+
+// nextFrame reads one point frame off frames, honoring ctx first. ok is
+// false once the zero-length terminator frame is read, signaling a clean
+// end of the point stream. Each point is decoded from its own frame rather
+// than from one long-lived stream so memory use stays bounded by a single
+// point's encoded size, not by shard size.
+//
+// A frame read already blocked on the network when ctx is canceled doesn't
+// notice the ctx.Done() check below; it unblocks because the connection's
+// watcher goroutine (see CreateIteratorContext) forces the deadline,
+// surfacing as a timeout error on the underlying conn rather than
+// ctx.Err() directly, so that error is translated back to ctx.Err() here.
+func nextFrame(ctx context.Context, frames *bufio.Reader) (body []byte, ok bool, err error) {
+	if ctx != nil {
+		select {
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		default:
+		}
+	}
+	body, ok, err = transport.ReadFramedBytes(frames)
+	if err != nil && ctx != nil {
+		err = ctxErr(ctx, err)
+	}
+	return body, ok, err
+}
+
+// RemoteFloatIterator reads float points, one length-prefixed frame at a
+// time, off a pooled connection to a remote node. It honors ctx.Done() so a
+// client disconnect or a query timeout aborts the outstanding shard read
+// instead of blocking until the remote side finishes streaming.
+type RemoteFloatIterator struct {
+	Frames      *bufio.Reader
+	Closed      bool
+	CloseReader func(drained bool)
+	ctx         context.Context
+	drained     bool
+}
+
+// Next returns the next point in the stream, or a nil point once the
+// terminator frame is reached.
+func (itr *RemoteFloatIterator) Next() (*influxql.FloatPoint, error) {
+	if itr.Closed {
+		return nil, nil
+	}
+
+	body, ok, err := nextFrame(itr.ctx, itr.Frames)
+	if err != nil || !ok {
+		itr.drained = err == nil
+		return nil, err
+	}
+
+	p := &influxql.FloatPoint{}
+	if err := influxql.NewPointDecoder(bytes.NewReader(body)).DecodeFloatPoint(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Close releases the underlying connection. It is safe to call more than
+// once.
+func (itr *RemoteFloatIterator) Close() error {
+	if !itr.Closed {
+		itr.Closed = true
+		itr.CloseReader(itr.drained)
+	}
+	return nil
+}
+
+// Stats returns the iterator's stats. Remote iterators don't currently
+// expose any.
+func (itr *RemoteFloatIterator) Stats() influxql.IteratorStats { return influxql.IteratorStats{} }
+
+// RemoteIntegerIterator is the integer-valued counterpart of
+// RemoteFloatIterator. See its docs for behavior.
+type RemoteIntegerIterator struct {
+	Frames      *bufio.Reader
+	Closed      bool
+	CloseReader func(drained bool)
+	ctx         context.Context
+	drained     bool
+}
+
+// Next returns the next point in the stream, or a nil point once the
+// terminator frame is reached.
+func (itr *RemoteIntegerIterator) Next() (*influxql.IntegerPoint, error) {
+	if itr.Closed {
+		return nil, nil
+	}
+
+	body, ok, err := nextFrame(itr.ctx, itr.Frames)
+	if err != nil || !ok {
+		itr.drained = err == nil
+		return nil, err
+	}
+
+	p := &influxql.IntegerPoint{}
+	if err := influxql.NewPointDecoder(bytes.NewReader(body)).DecodeIntegerPoint(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Close releases the underlying connection. It is safe to call more than
+// once.
+func (itr *RemoteIntegerIterator) Close() error {
+	if !itr.Closed {
+		itr.Closed = true
+		itr.CloseReader(itr.drained)
+	}
+	return nil
+}
+
+// Stats returns the iterator's stats. Remote iterators don't currently
+// expose any.
+func (itr *RemoteIntegerIterator) Stats() influxql.IteratorStats { return influxql.IteratorStats{} }
+
+// RemoteStringIterator is the string-valued counterpart of
+// RemoteFloatIterator. See its docs for behavior.
+type RemoteStringIterator struct {
+	Frames      *bufio.Reader
+	Closed      bool
+	CloseReader func(drained bool)
+	ctx         context.Context
+	drained     bool
+}
+
+// Next returns the next point in the stream, or a nil point once the
+// terminator frame is reached.
+func (itr *RemoteStringIterator) Next() (*influxql.StringPoint, error) {
+	if itr.Closed {
+		return nil, nil
+	}
+
+	body, ok, err := nextFrame(itr.ctx, itr.Frames)
+	if err != nil || !ok {
+		itr.drained = err == nil
+		return nil, err
+	}
+
+	p := &influxql.StringPoint{}
+	if err := influxql.NewPointDecoder(bytes.NewReader(body)).DecodeStringPoint(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Close releases the underlying connection. It is safe to call more than
+// once.
+func (itr *RemoteStringIterator) Close() error {
+	if !itr.Closed {
+		itr.Closed = true
+		itr.CloseReader(itr.drained)
+	}
+	return nil
+}
+
+// Stats returns the iterator's stats. Remote iterators don't currently
+// expose any.
+func (itr *RemoteStringIterator) Stats() influxql.IteratorStats { return influxql.IteratorStats{} }
+
+// RemoteBooleanIterator is the boolean-valued counterpart of
+// RemoteFloatIterator. See its docs for behavior.
+type RemoteBooleanIterator struct {
+	Frames      *bufio.Reader
+	Closed      bool
+	CloseReader func(drained bool)
+	ctx         context.Context
+	drained     bool
+}
+
+// Next returns the next point in the stream, or a nil point once the
+// terminator frame is reached.
+func (itr *RemoteBooleanIterator) Next() (*influxql.BooleanPoint, error) {
+	if itr.Closed {
+		return nil, nil
+	}
+
+	body, ok, err := nextFrame(itr.ctx, itr.Frames)
+	if err != nil || !ok {
+		itr.drained = err == nil
+		return nil, err
+	}
+
+	p := &influxql.BooleanPoint{}
+	if err := influxql.NewPointDecoder(bytes.NewReader(body)).DecodeBooleanPoint(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Close releases the underlying connection. It is safe to call more than
+// once.
+func (itr *RemoteBooleanIterator) Close() error {
+	if !itr.Closed {
+		itr.Closed = true
+		itr.CloseReader(itr.drained)
+	}
+	return nil
+}
+
+// Stats returns the iterator's stats. Remote iterators don't currently
+// expose any.
+func (itr *RemoteBooleanIterator) Stats() influxql.IteratorStats { return influxql.IteratorStats{} }
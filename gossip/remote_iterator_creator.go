@@ -1,28 +1,86 @@
 package gossip
 
 import (
-	"bytes"
-	"encoding/json"
+	"bufio"
+	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"math"
+	"net"
 	"net/http"
-	"net/url"
+	"sync"
 	"time"
 
 	"github.com/gogo/protobuf/proto"
+	"github.com/influxdata/influxdb/gossip/transport"
 	"github.com/influxdata/influxdb/influxql"
-	"github.com/spf13/viper"
 )
 
+// connPool is the process-wide set of pooled peer connections shared by
+// every RemoteIteratorCreator. Peers are addressed by BindAddress, so one
+// pool entry is reused across shards that happen to live on the same node.
+var connPool = transport.NewPool(4)
+
 // RemoteIteratorCreator implements influxql.IteratorCreator
 type RemoteIteratorCreator struct {
 	Store   *TSDBStore
 	ShardID uint64
 	NodeID  uint64
-	// ShardIDs []uint64
+
+	// NodeIDs, when set, lists every replica that holds ShardID. CreateIterator
+	// tries the replica DefaultCoordinator currently rates fastest and hedges
+	// to the next-best one if it hasn't answered within the coordinator's
+	// HedgeDelay. NodeID is used as a single-replica fallback when this is
+	// empty, so existing callers are unaffected.
+	NodeIDs []uint64
+}
+
+// shardRead holds the outcome of one attempt to read ShardID from a single
+// replica: either a usable connection with its response header already
+// decoded and a buffered reader positioned at the start of the point-frame
+// stream, or an error.
+type shardRead struct {
+	nodeID uint64
+	addr   string
+	conn   net.Conn
+	header transport.Envelope
+	frames *bufio.Reader
+	err    error
+}
+
+// inFlight lets a hedged read be aborted after it has already dialed:
+// readShard calls abort on the loser once a winner is known (or on the
+// primary when ctx is done first), which forces its blocked read to fail
+// immediately instead of running to completion.
+type inFlight struct {
+	mu      sync.Mutex
+	conn    net.Conn
+	aborted bool
+}
+
+func (f *inFlight) set(conn net.Conn) {
+	f.mu.Lock()
+	f.conn = conn
+	f.mu.Unlock()
+}
+
+func (f *inFlight) abort() {
+	f.mu.Lock()
+	f.aborted = true
+	if f.conn != nil {
+		f.conn.SetDeadline(time.Now())
+	}
+	f.mu.Unlock()
+}
+
+// wasAborted reports whether abort has already been called. readShardFrom
+// checks this before recording a failure against the breaker/membership so
+// that a hedge loser, which fails by design once abort forces its deadline,
+// isn't mistaken for a genuinely unreachable node.
+func (f *inFlight) wasAborted() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.aborted
 }
 
 // NodesList is used to return list of nodes
@@ -36,6 +94,15 @@ type NodesList struct {
 
 // CreateIterator Creates a simple iterator for use in an InfluxQL query for the remote node
 func (ric *RemoteIteratorCreator) CreateIterator(opt influxql.IteratorOptions) (influxql.Iterator, error) {
+	return ric.CreateIteratorContext(context.Background(), opt)
+}
+
+// CreateIteratorContext behaves like CreateIterator but honors ctx: if ctx
+// is canceled or its deadline expires before the remote read completes, the
+// in-flight connection is abandoned and ctx.Err() is returned. Once the
+// iterator is constructed, its Next() also checks ctx so a client
+// disconnect or query-timeout stops an in-progress shard scan.
+func (ric *RemoteIteratorCreator) CreateIteratorContext(ctx context.Context, opt influxql.IteratorOptions) (influxql.Iterator, error) {
 	aliveNodes, err := AliveNodesMap()
 	log.Printf("************* NodeID = %d, aliveNodes = %+v", ric.NodeID, aliveNodes[ric.NodeID])
 
@@ -48,6 +115,7 @@ func (ric *RemoteIteratorCreator) CreateIterator(opt influxql.IteratorOptions) (
 	cmd := &ReadShardCommand{
 		ShardID:         ric.ShardID,
 		IteratorOptions: optBinary,
+		NodeIDs:         ric.NodeIDs,
 	}
 
 	data, err := proto.Marshal(cmd)
@@ -56,51 +124,245 @@ func (ric *RemoteIteratorCreator) CreateIterator(opt influxql.IteratorOptions) (
 		return nil, err
 	}
 
-	f := func() (*http.Request, error) {
-		url := "http://" + aliveNodes[ric.NodeID].BindAddress + "/read"
-		return http.NewRequest("POST", url, bytes.NewBuffer(data))
-	}
-
-	resp, err := ExpBackoffRequest(f)
-	if err != nil {
-		log.Printf("Failed to read shards from remote node with ID: %d", ric.NodeID)
-		return nil, err
+	read := ric.readShard(ctx, aliveNodes, data)
+	if read.err != nil {
+		log.Printf("Failed to read shards from remote node with ID: %d: %s", read.nodeID, read.err.Error())
+		return nil, read.err
 	}
 
 	respMessage := &ReadShardCommandResponse{}
-	respBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Failed while reading received http body: %s", err.Error())
-		return nil, err
-	}
-	err = proto.Unmarshal(respBody, respMessage)
-	if err != nil {
+	if err := proto.Unmarshal(read.header.Body, respMessage); err != nil {
+		connPool.Discard(read.conn)
 		log.Printf("Error while unmarshaling response: %s", err.Error())
 		return nil, err
 	}
 
-	dec := influxql.NewPointDecoder(resp.Body)
+	// The connection stays open for the lifetime of the iterator: points
+	// are decoded one frame at a time off read.frames rather than buffered
+	// up front, so Close (not this function) is what returns it to the
+	// pool, and only once the point stream has actually been drained.
+	//
+	// readShardFrom clears the connection's deadline once the header frame
+	// is read, since a per-frame deadline derived from ctx.Deadline() would
+	// need continual re-arming as points trickle in. Instead, a watcher
+	// goroutine holds the deadline at ctx's cancellation for the rest of
+	// the connection's life: a query timeout or client disconnect during
+	// iteration forces a blocked frame read to fail instead of hanging
+	// until the peer sends data or closes the socket.
+	addr, conn := read.addr, read.conn
+	watchDone := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-watchDone:
+		}
+	}()
+	closeBody := func(drained bool) {
+		close(watchDone)
+		if drained {
+			connPool.Put(addr, conn)
+		} else {
+			connPool.Discard(conn)
+		}
+	}
+
 	var iter influxql.Iterator
 	iterType := respMessage.Type
-	closeBody := func() {
-		resp.Body.Close()
-	}
 	switch iterType {
 	case ReadShardCommandResponse_FLOAT:
-		iter = &RemoteFloatIterator{PointDecoder: dec, Closed: false, CloseReader: closeBody}
+		iter = &RemoteFloatIterator{Frames: read.frames, CloseReader: closeBody, ctx: ctx}
 	case ReadShardCommandResponse_INTEGER:
-		iter = &RemoteIntegerIterator{PointDecoder: dec, Closed: false, CloseReader: closeBody}
+		iter = &RemoteIntegerIterator{Frames: read.frames, CloseReader: closeBody, ctx: ctx}
 	case ReadShardCommandResponse_STRING:
-		iter = &RemoteStringIterator{PointDecoder: dec, Closed: false, CloseReader: closeBody}
+		iter = &RemoteStringIterator{Frames: read.frames, CloseReader: closeBody, ctx: ctx}
 	case ReadShardCommandResponse_BOOLEAN:
-		iter = &RemoteBooleanIterator{PointDecoder: dec, Closed: false, CloseReader: closeBody}
+		iter = &RemoteBooleanIterator{Frames: read.frames, CloseReader: closeBody, ctx: ctx}
 	default:
+		connPool.Discard(conn)
 		return nil, fmt.Errorf("Unsupported iterator type: %d", iterType)
 	}
 
 	return iter, nil
 }
 
+// readShard fetches the ReadShardCommand response and point stream for a
+// shard. When ric.NodeIDs names more than one replica, it dispatches to
+// the replica DefaultCoordinator currently ranks fastest and, if that
+// replica hasn't produced a response header within DefaultCoordinator's
+// HedgeDelay, also dispatches to the next-ranked replica; whichever
+// responds first wins and the other is aborted. A primary that is fenced
+// off by its own circuit breaker is treated the same as a hedge timeout:
+// the secondary is dispatched immediately rather than surfacing the
+// breaker's error as the call's final result.
+func (ric *RemoteIteratorCreator) readShard(ctx context.Context, aliveNodes map[uint64]NodesList, data []byte) shardRead {
+	nodeIDs := ric.NodeIDs
+	if len(nodeIDs) == 0 {
+		nodeIDs = []uint64{ric.NodeID}
+	}
+	if len(nodeIDs) == 1 {
+		id := nodeIDs[0]
+		return ric.readShardFrom(ctx, &inFlight{}, id, aliveNodes[id].BindAddress, data)
+	}
+
+	ranked := DefaultCoordinator.Rank(nodeIDs)
+	results := make(chan shardRead, len(ranked))
+
+	primary := &inFlight{}
+	go func(id uint64) {
+		results <- ric.readShardFrom(ctx, primary, id, aliveNodes[id].BindAddress, data)
+	}(ranked[0])
+
+	timer := time.NewTimer(DefaultCoordinator.HedgeDelay)
+	defer timer.Stop()
+
+	primaryDone := false
+	select {
+	case res := <-results:
+		if res.err == nil {
+			return res
+		}
+		// The primary failed outright (circuit breaker open, dial/write/read
+		// error, or anything else) rather than merely running slow; there's
+		// no connection left to reclaim for it once readShardFrom has
+		// returned, so fall through and dispatch the secondary now instead
+		// of waiting out the rest of HedgeDelay on a replica that has
+		// already lost.
+		primaryDone = true
+	case <-ctx.Done():
+		// The primary may already hold a connection; reclaim it in the
+		// background once its goroutine unblocks instead of leaking it,
+		// the same as the loser-after-hedge path below does.
+		primary.abort()
+		go func() {
+			if res := <-results; res.conn != nil {
+				connPool.Discard(res.conn)
+			}
+		}()
+		return shardRead{err: ctx.Err()}
+	case <-timer.C:
+	}
+
+	secondary := &inFlight{}
+	go func(id uint64) {
+		results <- ric.readShardFrom(ctx, secondary, id, aliveNodes[id].BindAddress, data)
+	}(ranked[1])
+
+	winner := <-results
+	if primaryDone {
+		return winner
+	}
+
+	if winner.nodeID == ranked[0] {
+		secondary.abort()
+	} else {
+		primary.abort()
+	}
+	// The loser is still running its read in the background; once it
+	// unblocks (from the abort above or its own completion) reclaim its
+	// connection instead of leaking it.
+	go func() {
+		if loser := <-results; loser.conn != nil {
+			connPool.Discard(loser.conn)
+		}
+	}()
+
+	return winner
+}
+
+// readShardFrom performs a single read attempt against nodeID, recording
+// the round-trip latency (time to the response header) in
+// DefaultCoordinator so future Rank calls favor fast, lightly-loaded
+// replicas. flight.set is called as soon as a connection is obtained so a
+// concurrent hedge can abort this attempt via flight.abort.
+func (ric *RemoteIteratorCreator) readShardFrom(ctx context.Context, flight *inFlight, nodeID uint64, addr string, data []byte) shardRead {
+	cb := DefaultRetrier.breaker(nodeID)
+	if !cb.Allow() {
+		return shardRead{nodeID: nodeID, err: fmt.Errorf("gossip: circuit breaker open for node %d: %w", nodeID, ErrBreakerOpen)}
+	}
+
+	ps := DefaultCoordinator.peerStats(nodeID)
+	ps.startRequest()
+	defer ps.endRequest()
+
+	conn, err := connPool.Get(addr)
+	if err != nil {
+		if !flight.wasAborted() {
+			cb.RecordFailure()
+			DefaultMembership.MarkSuspect(nodeID)
+		}
+		return shardRead{nodeID: nodeID, err: err}
+	}
+	flight.set(conn)
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	// A ctx with no explicit Deadline (a plain context.WithCancel, as a
+	// client disconnect typically surfaces) wouldn't otherwise unblock the
+	// write/header-read below until the OS-level connection gave up on its
+	// own; watch ctx.Done() for the rest of this call the same way
+	// CreateIteratorContext does for the point-streaming phase that follows
+	// a successful return here.
+	watchDone := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-watchDone:
+		}
+	}()
+	defer close(watchDone)
+
+	start := time.Now()
+	if err := transport.WriteMessage(conn, transport.CommandReadShard, data); err != nil {
+		connPool.Discard(conn)
+		// A hedge loser fails here by design once abort forces its
+		// deadline; that's not evidence the node is unhealthy, so don't
+		// penalize its breaker or membership state for losing the race.
+		if !flight.wasAborted() {
+			cb.RecordFailure()
+			DefaultMembership.MarkSuspect(nodeID)
+		}
+		return shardRead{nodeID: nodeID, err: ctxErr(ctx, err)}
+	}
+
+	// The response begins with a single varint-length-prefixed header frame
+	// naming the iterator type, followed by the point stream itself; both
+	// are read off the same buffered reader so the point frames that come
+	// after the header are read in 64KiB chunks rather than all at once.
+	frames := transport.NewPointReader(conn)
+	headerBody, ok, err := transport.ReadFramedBytes(frames)
+	if err != nil || !ok {
+		connPool.Discard(conn)
+		if !flight.wasAborted() {
+			cb.RecordFailure()
+			DefaultMembership.MarkSuspect(nodeID)
+		}
+		if err == nil {
+			err = errors.New("gossip: empty response header")
+		}
+		return shardRead{nodeID: nodeID, err: ctxErr(ctx, err)}
+	}
+	ps.Observe(time.Since(start))
+	conn.SetDeadline(time.Time{})
+	cb.RecordSuccess()
+
+	return shardRead{nodeID: nodeID, addr: addr, conn: conn, header: transport.Envelope{Body: headerBody}, frames: frames}
+}
+
+// ctxErr returns ctx.Err() when ctx has already been canceled or has hit
+// its deadline, since that's almost always the real cause of a read/write
+// failure on a connection whose deadline was derived from ctx; otherwise
+// it returns err unchanged.
+func ctxErr(ctx context.Context, err error) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		return err
+	}
+}
+
 // FieldDimensions Returns the unique fields and dimensions across a list of sources from the remote node
 func (ric *RemoteIteratorCreator) FieldDimensions(sources influxql.Sources) (fields map[string]influxql.DataType, dimensions map[string]struct{}, err error) {
 	sourceBinary, err := sources.MarshalBinary()
@@ -118,24 +380,15 @@ func (ric *RemoteIteratorCreator) FieldDimensions(sources influxql.Sources) (fie
 	if err != nil {
 		return nil, nil, err
 	}
-	f := func() (*http.Request, error) {
-		log.Printf("ric.NodeID=%d, aliveNodes[ric.NodeID]=%+v", ric.NodeID, aliveNodes[ric.NodeID])
-		url := "http://" + aliveNodes[ric.NodeID].BindAddress + "/fielddimensions"
-		return http.NewRequest("POST", url, bytes.NewBuffer(fdcBinary))
-	}
+	addr := aliveNodes[ric.NodeID].BindAddress
 
-	resp, err := ExpBackoffRequest(f)
+	respBody, err := rpcExchange(addr, transport.CommandFieldDimensions, fdcBinary)
 	if err != nil {
+		log.Printf("Failed to fetch field dimensions from remote node with ID: %d: %s", ric.NodeID, err.Error())
 		return nil, nil, err
 	}
 
 	respMessage := &FieldDimensionsCommandResponse{}
-	respBody, err := ioutil.ReadAll(resp.Body)
-	defer resp.Body.Close()
-	if err != nil {
-		log.Printf("Failed while reading received http body: %s", err.Error())
-		return nil, nil, err
-	}
 	err = proto.Unmarshal(respBody, respMessage)
 	if err != nil {
 		log.Printf("Error while unmarshaling response: %s", err.Error())
@@ -176,23 +429,15 @@ func (ric *RemoteIteratorCreator) ExpandSources(sources influxql.Sources) (influ
 	if err != nil {
 		return nil, err
 	}
+	addr := aliveNodes[ric.NodeID].BindAddress
 
-	f := func() (*http.Request, error) {
-		url := "http://" + aliveNodes[ric.NodeID].BindAddress + "/expandsources"
-		return http.NewRequest("POST", url, bytes.NewBuffer(cmdBinary))
-	}
-	resp, err := ExpBackoffRequest(f)
+	respBody, err := rpcExchange(addr, transport.CommandExpandSources, cmdBinary)
 	if err != nil {
+		log.Printf("Failed to expand sources on remote node with ID: %d: %s", ric.NodeID, err.Error())
 		return nil, err
 	}
 
 	respMessage := &ExpandSourcesCommandResponse{}
-	respBody, err := ioutil.ReadAll(resp.Body)
-	defer resp.Body.Close()
-	if err != nil {
-		log.Printf("Failed while reading received http body: %s", err.Error())
-		return nil, err
-	}
 	err = proto.Unmarshal(respBody, respMessage)
 	if err != nil {
 		log.Printf("Error while unmarshaling response: %s", err.Error())
@@ -210,49 +455,49 @@ func (ric *RemoteIteratorCreator) ExpandSources(sources influxql.Sources) (influ
 	return respSources, nil
 }
 
-// AliveNodesMap foo
-func AliveNodesMap() (map[uint64]NodesList, error) {
-	f := func() (*http.Request, error) {
-		url := viper.GetString("CFLUX_ENDPOINT") + "/nodes/" + url.QueryEscape(viper.GetString("CLUSTER"))
-		return http.NewRequest("GET", url, nil)
-	}
-	resp, err := ExpBackoffRequest(f)
+// rpcExchange sends a single framed request to addr and returns the body of
+// the single framed response, using a pooled connection rather than
+// dialing fresh for every call.
+func rpcExchange(addr string, cmd transport.Command, body []byte) ([]byte, error) {
+	conn, err := connPool.Get(addr)
 	if err != nil {
 		return nil, err
 	}
-	var nodeList []NodesList
-	nodeMap := map[uint64]NodesList{}
-	err = json.NewDecoder(resp.Body).Decode(&nodeList)
-	if err != nil {
+
+	if err := transport.WriteMessage(conn, cmd, body); err != nil {
+		connPool.Discard(conn)
 		return nil, err
 	}
-	for _, node := range nodeList {
-		nodeMap[node.ID] = node
-		log.Printf("***** assign alive to %d = %+v", node.ID, node)
+
+	resp, err := transport.ReadMessage(conn)
+	if err != nil {
+		connPool.Discard(conn)
+		return nil, err
 	}
-	return nodeMap, nil
+	connPool.Put(addr, conn)
+
+	return resp.Body, nil
 }
 
-// ExpBackoffRequest foo
+// AliveNodesMap returns the locally cached view of cluster membership,
+// transparently refreshing it from CFLUX_ENDPOINT on a cache miss. It used
+// to issue a fresh HTTP GET on every call; callers on the CreateIterator
+// hot path now share DefaultMembership's cache instead.
+func AliveNodesMap() (map[uint64]NodesList, error) {
+	return DefaultMembership.Snapshot()
+}
+
+// ExpBackoffRequest issues an HTTP request built by f, retrying per
+// DefaultRetrier's policy: jittered backoff, a predicate that only retries
+// transport errors and 429/503 (honoring Retry-After), and a circuit
+// breaker that fails fast once a peer has failed repeatedly. It used to
+// hardcode 5 attempts of pure exponential backoff with no jitter and no
+// circuit breaking; callers are unaffected since the signature is
+// unchanged.
 func ExpBackoffRequest(f func() (*http.Request, error)) (*http.Response, error) {
-	client := &http.Client{}
-	var resp *http.Response
-	var req *http.Request
-	var err error
-
-	for attempt := 1; attempt < 6; attempt++ {
-		req, err = f()
-		// log.Printf("req=%+v", req)
-		if err != nil {
-			return nil, err
-		}
-		resp, err = client.Do(req)
-		if err == nil {
-			return resp, err
-		}
-		backoff := (math.Pow(2, float64(attempt)) - 1) / 2
-		time.Sleep(time.Duration(backoff) * time.Second)
+	resp, err := DefaultRetrier.Do(0, f)
+	if err != nil {
+		log.Printf("Error while connecting to Clusterflux: %s", err.Error())
 	}
-	log.Printf("Error while connecting to Clusterflux: %s", err.Error())
 	return resp, err
 }
@@ -0,0 +1,124 @@
+package gossip
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/influxdata/influxdb/gossip/transport"
+	"github.com/influxdata/influxdb/influxql"
+)
+
+func encodedFloatFrames(t *testing.T, points ...*influxql.FloatPoint) *bufio.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, p := range points {
+		var pbuf bytes.Buffer
+		if err := influxql.NewPointEncoder(&pbuf).EncodeFloatPoint(p); err != nil {
+			t.Fatalf("EncodeFloatPoint: %s", err)
+		}
+		if err := transport.WriteFramedBytes(&buf, pbuf.Bytes()); err != nil {
+			t.Fatalf("WriteFramedBytes: %s", err)
+		}
+	}
+	if err := transport.WriteFramedBytes(&buf, nil); err != nil {
+		t.Fatalf("WriteFramedBytes terminator: %s", err)
+	}
+	return bufio.NewReader(&buf)
+}
+
+func TestRemoteFloatIterator_DrainsOnCleanEOF(t *testing.T) {
+	frames := encodedFloatFrames(t, &influxql.FloatPoint{Name: "m", Value: 1})
+
+	var drainedWith *bool
+	itr := &RemoteFloatIterator{
+		Frames: frames,
+		CloseReader: func(drained bool) {
+			v := drained
+			drainedWith = &v
+		},
+		ctx: context.Background(),
+	}
+
+	p, err := itr.Next()
+	if err != nil || p == nil {
+		t.Fatalf("first Next: got point=%v err=%v", p, err)
+	}
+
+	p, err = itr.Next()
+	if err != nil || p != nil {
+		t.Fatalf("terminator Next: got point=%v err=%v, want nil, nil", p, err)
+	}
+
+	if err := itr.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	if drainedWith == nil || !*drainedWith {
+		t.Fatal("Close should report drained=true after the stream ran to its terminator")
+	}
+}
+
+func TestRemoteFloatIterator_DoesNotDrainOnError(t *testing.T) {
+	var buf bytes.Buffer
+	if err := transport.WriteFramedBytes(&buf, []byte("0123456789")); err != nil {
+		t.Fatalf("WriteFramedBytes: %s", err)
+	}
+	truncated := buf.Bytes()[:buf.Len()-1] // drop the last body byte
+	frames := bufio.NewReader(bytes.NewReader(truncated))
+
+	var drainedWith *bool
+	itr := &RemoteFloatIterator{
+		Frames: frames,
+		CloseReader: func(drained bool) {
+			v := drained
+			drainedWith = &v
+		},
+		ctx: context.Background(),
+	}
+
+	if _, err := itr.Next(); err == nil {
+		t.Fatal("expected an error reading a truncated frame")
+	}
+
+	if err := itr.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	if drainedWith == nil || *drainedWith {
+		t.Fatal("Close should report drained=false after Next returned an error")
+	}
+}
+
+func TestRemoteFloatIterator_CloseIsIdempotent(t *testing.T) {
+	frames := encodedFloatFrames(t)
+
+	var calls int
+	itr := &RemoteFloatIterator{
+		Frames:      frames,
+		CloseReader: func(bool) { calls++ },
+		ctx:         context.Background(),
+	}
+
+	itr.Close()
+	itr.Close()
+	if calls != 1 {
+		t.Fatalf("CloseReader called %d times, want 1", calls)
+	}
+}
+
+func TestRemoteFloatIterator_NextHonorsCanceledContext(t *testing.T) {
+	frames := encodedFloatFrames(t, &influxql.FloatPoint{Name: "m", Value: 1})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	itr := &RemoteFloatIterator{
+		Frames:      frames,
+		CloseReader: func(bool) {},
+		ctx:         ctx,
+	}
+
+	if _, err := itr.Next(); err != ctx.Err() {
+		t.Fatalf("got %v, want %v", err, ctx.Err())
+	}
+}
@@ -0,0 +1,212 @@
+package gossip
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Retrier retries an HTTP round trip with configurable backoff, a
+// predicate deciding which outcomes are worth retrying, and a per-peer
+// circuit breaker so a consistently failing node gets fenced off instead
+// of retried forever. DefaultRetrier replaces the old ExpBackoffRequest's
+// hardcoded 5-attempt pure-exponential, retry-everything behavior.
+type Retrier struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+
+	// Jitter transforms a computed backoff duration before each sleep. The
+	// zero value behaves as FullJitter.
+	Jitter func(d time.Duration) time.Duration
+
+	// ShouldRetry decides whether an outcome should be retried. resp is nil
+	// when err is a transport-level failure. The zero value behaves as
+	// defaultShouldRetry: retry transport errors and 429/503, treat any
+	// other response (including other 4xx) as final.
+	ShouldRetry func(resp *http.Response, err error) bool
+
+	// BreakerThreshold and BreakerCooldown configure the CircuitBreaker
+	// created the first time a given peer is seen. Zero values fall back
+	// to 5 consecutive failures and a 30s cooldown.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	breakers sync.Map // uint64 -> *CircuitBreaker
+}
+
+// DefaultRetrier is the retry policy used by ExpBackoffRequest.
+var DefaultRetrier = &Retrier{
+	MaxAttempts: 5,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    16 * time.Second,
+}
+
+// FullJitter returns a uniform random duration in [0, d), the jitter
+// strategy AWS's backoff guidance recommends over no jitter or equal
+// jitter for avoiding synchronized retry storms across clients.
+func FullJitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+func defaultShouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	default:
+		return false
+	}
+}
+
+// retryAfter reports the delay named by a Retry-After response header, in
+// whole seconds, if present.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	secs, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(secs) * time.Second, true
+}
+
+// PeerBreakerState names a peer's current CircuitBreaker state, for
+// exposing as a metric.
+type PeerBreakerState struct {
+	NodeID uint64
+	State  CircuitBreakerState
+}
+
+// BreakerStates returns the current breaker state of every peer this
+// Retrier has made a request to, so operators can see which nodes are
+// currently fenced off.
+func (r *Retrier) BreakerStates() []PeerBreakerState {
+	var states []PeerBreakerState
+	r.breakers.Range(func(key, value interface{}) bool {
+		states = append(states, PeerBreakerState{NodeID: key.(uint64), State: value.(*CircuitBreaker).State()})
+		return true
+	})
+	return states
+}
+
+func (r *Retrier) breaker(peer uint64) *CircuitBreaker {
+	threshold := r.BreakerThreshold
+	if threshold <= 0 {
+		threshold = 5
+	}
+	cooldown := r.BreakerCooldown
+	if cooldown <= 0 {
+		cooldown = 30 * time.Second
+	}
+	v, _ := r.breakers.LoadOrStore(peer, NewCircuitBreaker(threshold, cooldown))
+	return v.(*CircuitBreaker)
+}
+
+func (r *Retrier) shouldRetry(resp *http.Response, err error) bool {
+	if r.ShouldRetry != nil {
+		return r.ShouldRetry(resp, err)
+	}
+	return defaultShouldRetry(resp, err)
+}
+
+func (r *Retrier) backoff(attempt int) time.Duration {
+	base := r.BaseDelay
+	if base <= 0 {
+		base = 500 * time.Millisecond
+	}
+	max := r.MaxDelay
+	if max <= 0 {
+		max = 30 * time.Second
+	}
+
+	d := base * time.Duration(int64(1)<<uint(attempt))
+	if d <= 0 || d > max {
+		d = max
+	}
+
+	jitter := r.Jitter
+	if jitter == nil {
+		jitter = FullJitter
+	}
+	return jitter(d)
+}
+
+// Do executes f (which builds the *http.Request to send), retrying per the
+// policy above. peer identifies the node being called, for per-peer
+// circuit-breaker bookkeeping; pass 0 for requests that aren't scoped to a
+// single node, such as the CFLUX_ENDPOINT membership poll.
+func (r *Retrier) Do(peer uint64, f func() (*http.Request, error)) (*http.Response, error) {
+	cb := r.breaker(peer)
+	if !cb.Allow() {
+		return nil, fmt.Errorf("gossip: circuit breaker open for node %d: %w", peer, ErrBreakerOpen)
+	}
+
+	maxAttempts := r.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 1
+	}
+	client := &http.Client{}
+
+	var resp *http.Response
+	var err error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		var req *http.Request
+		req, err = f()
+		if err != nil {
+			cb.RecordFailure()
+			return nil, err
+		}
+
+		resp, err = client.Do(req)
+		if !r.shouldRetry(resp, err) {
+			if err != nil {
+				cb.RecordFailure()
+				return nil, err
+			}
+			cb.RecordSuccess()
+			return resp, nil
+		}
+
+		cb.RecordFailure()
+		delay := r.backoff(attempt)
+		if wait, ok := retryAfter(resp); ok {
+			delay = wait
+		}
+		// resp is about to be discarded (the next attempt overwrites it, or
+		// the loop exits below); drain and close its body now rather than
+		// leaking the connection.
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			resp = nil
+		}
+		if attempt == maxAttempts-1 {
+			break
+		}
+		time.Sleep(delay)
+	}
+
+	if err == nil {
+		err = fmt.Errorf("gossip: exhausted %d attempts against node %d", maxAttempts, peer)
+	}
+	// net/http's Do contract reserves a non-nil Response alongside a
+	// non-nil error for a failed CheckRedirect; honor that here too so
+	// callers can assume resp == nil whenever err != nil, as
+	// ExpBackoffRequest's callers already do.
+	return nil, err
+}
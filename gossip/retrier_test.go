@@ -0,0 +1,132 @@
+package gossip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetrier_RetriesOn503AndRespectsRetryAfter(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	r := &Retrier{
+		MaxAttempts: 3,
+		BaseDelay:   time.Millisecond,
+		MaxDelay:    time.Millisecond,
+		Jitter:      func(d time.Duration) time.Duration { return d },
+	}
+
+	resp, err := r.Do(9001, func() (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, srv.URL, nil)
+	})
+	if err != nil {
+		t.Fatalf("Do: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want 200", resp.StatusCode)
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Fatalf("handler called %d times, want 2", got)
+	}
+}
+
+func TestRetrier_DoesNotRetryOtherClientErrors(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	r := &Retrier{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+	resp, err := r.Do(9002, func() (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, srv.URL, nil)
+	})
+	if err != nil {
+		t.Fatalf("Do: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("got status %d, want 404", resp.StatusCode)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("a non-retried status should only be requested once, got %d calls", got)
+	}
+}
+
+func TestRetrier_ExhaustsAttemptsWithoutLeakingResponse(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	r := &Retrier{
+		MaxAttempts: 2,
+		BaseDelay:   time.Millisecond,
+		MaxDelay:    time.Millisecond,
+		Jitter:      func(d time.Duration) time.Duration { return d },
+	}
+
+	resp, err := r.Do(9003, func() (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, srv.URL, nil)
+	})
+	if err == nil {
+		t.Fatal("expected an error once attempts are exhausted")
+	}
+	if resp != nil {
+		t.Fatal("Do should not return a non-nil response alongside a non-nil error")
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Fatalf("handler called %d times, want 2", got)
+	}
+}
+
+func TestRetrier_BreakerOpenFailsFastWithoutCallingF(t *testing.T) {
+	r := &Retrier{BreakerThreshold: 1, BreakerCooldown: time.Minute}
+	r.breaker(9004).RecordFailure() // opens on the first failure
+
+	var called bool
+	_, err := r.Do(9004, func() (*http.Request, error) {
+		called = true
+		return http.NewRequest(http.MethodGet, "http://example.invalid", nil)
+	})
+	if err == nil {
+		t.Fatal("expected an error for a peer with an open breaker")
+	}
+	if called {
+		t.Fatal("Do should fail fast without calling f when the breaker is open")
+	}
+}
+
+func TestFullJitter_StaysInRange(t *testing.T) {
+	d := 100 * time.Millisecond
+	for i := 0; i < 50; i++ {
+		got := FullJitter(d)
+		if got < 0 || got >= d {
+			t.Fatalf("FullJitter(%s) returned %s, outside [0, %s)", d, got, d)
+		}
+	}
+}
+
+func TestFullJitter_ZeroDuration(t *testing.T) {
+	if got := FullJitter(0); got != 0 {
+		t.Fatalf("FullJitter(0) = %s, want 0", got)
+	}
+}
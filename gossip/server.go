@@ -0,0 +1,294 @@
+package gossip
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/influxdata/influxdb/gossip/transport"
+	"github.com/influxdata/influxdb/influxql"
+)
+
+// StoreDispatcher serves the TCP counterparts of CreateIterator,
+// FieldDimensions, and ExpandSources against the shards held by Store. It
+// implements transport.Dispatcher, so transport.ListenAndServe routes every
+// frame a peer's RemoteIteratorCreator sends over a pooled connection here.
+type StoreDispatcher struct {
+	Store *TSDBStore
+}
+
+// Dispatch routes env to the handler for its command.
+func (d *StoreDispatcher) Dispatch(conn net.Conn, env transport.Envelope) error {
+	switch env.Command {
+	case transport.CommandReadShard:
+		return d.dispatchReadShard(conn, env.Body)
+	case transport.CommandFieldDimensions:
+		return d.dispatchFieldDimensions(conn, env.Body)
+	case transport.CommandExpandSources:
+		return d.dispatchExpandSources(conn, env.Body)
+	default:
+		return fmt.Errorf("gossip: unknown command %d", env.Command)
+	}
+}
+
+// commandReadDeadline is the read deadline dispatchReadShard holds on conn
+// while it unmarshals the ReadShardCommand. The command's body has already
+// been read by serveConn (under RequestReadTimeout), so no read on conn
+// takes place while this deadline is in force.
+const commandReadDeadline = 30 * time.Second
+
+// dispatchReadShard writes a ReadShardCommandResponse header frame naming
+// the iterator's point type, followed by the shard's points one
+// varint-length-prefixed frame at a time, ending with the zero-length
+// terminator frame ReadFramedBytes expects. This is the wire format
+// readShardFrom reads on the client side.
+//
+// The scan is aborted as soon as the peer closes its side of conn: a
+// background goroutine holds a read outstanding on conn for the scan's
+// whole duration (the peer has no reason to send anything more once its
+// request is sent) and cancels ctx the moment that read returns, the
+// server-side counterpart of the client's own ctx-driven deadline watcher.
+func (d *StoreDispatcher) dispatchReadShard(conn net.Conn, body []byte) error {
+	conn.SetReadDeadline(time.Now().Add(commandReadDeadline))
+	cmd := &ReadShardCommand{}
+	if err := proto.Unmarshal(body, cmd); err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	var opt influxql.IteratorOptions
+	if err := opt.UnmarshalBinary(cmd.IteratorOptions); err != nil {
+		return err
+	}
+
+	ic, err := d.Store.IteratorCreator([]uint64{cmd.ShardID}, &opt)
+	if err != nil {
+		return err
+	}
+
+	itr, err := ic.CreateIterator(opt)
+	if err != nil {
+		return err
+	}
+	defer itr.Close()
+
+	respType, encode, err := pointEncoderFor(itr)
+	if err != nil {
+		return err
+	}
+
+	header, err := proto.Marshal(&ReadShardCommandResponse{Type: respType})
+	if err != nil {
+		return err
+	}
+	if err := transport.WriteFramedBytes(conn, header); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopWatch := make(chan struct{})
+	watchExited := make(chan struct{})
+	go func() {
+		watchConnClosed(conn, stopWatch, cancel)
+		close(watchExited)
+	}()
+	defer func() {
+		// Force the watcher's blocked Read to return before this
+		// connection goes back to serveConn's loop for its next request,
+		// the same deadline-forcing trick CreateIteratorContext's own
+		// watcher uses on the client side to reclaim a connection. close
+		// stopWatch first so the watcher can tell that unblock apart from
+		// a genuine peer close and skip canceling an already-finished scan.
+		close(stopWatch)
+		conn.SetReadDeadline(time.Now())
+		<-watchExited
+		cancel()
+	}()
+
+	if err := encode(ctx, conn); err != nil {
+		return err
+	}
+	return transport.WriteFramedBytes(conn, nil)
+}
+
+// watchConnClosed blocks on a read from conn, which the peer has no reason
+// to ever send on once its request is on its way, and cancels ctx once
+// that read unblocks for real: either the peer closed the connection, or
+// something else went wrong with it. Call this in its own goroutine; it
+// exits once dispatchReadShard forces it to unblock on scan completion
+// (reported via stopWatch) or the peer actually closes the connection.
+func watchConnClosed(conn net.Conn, stopWatch <-chan struct{}, cancel context.CancelFunc) {
+	var buf [1]byte
+	conn.Read(buf[:])
+	select {
+	case <-stopWatch:
+		// dispatchReadShard forced this Read to return on its way out; the
+		// scan already finished on its own, nothing to cancel.
+	default:
+		cancel()
+	}
+}
+
+// pointEncoderFor returns the response type tag for itr's concrete point
+// type along with a function that streams its points, each as its own
+// WriteFramedBytes frame, checking ctx before each point so a scan over a
+// large shard aborts promptly once the peer has gone away instead of
+// running to completion into a connection nobody's reading from anymore.
+func pointEncoderFor(itr influxql.Iterator) (ReadShardCommandResponse_Type, func(context.Context, io.Writer) error, error) {
+	switch itr := itr.(type) {
+	case influxql.FloatIterator:
+		return ReadShardCommandResponse_FLOAT, func(ctx context.Context, w io.Writer) error {
+			for {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				p, err := itr.Next()
+				if err != nil || p == nil {
+					return err
+				}
+				var buf bytes.Buffer
+				if err := influxql.NewPointEncoder(&buf).EncodeFloatPoint(p); err != nil {
+					return err
+				}
+				if err := transport.WriteFramedBytes(w, buf.Bytes()); err != nil {
+					return err
+				}
+			}
+		}, nil
+	case influxql.IntegerIterator:
+		return ReadShardCommandResponse_INTEGER, func(ctx context.Context, w io.Writer) error {
+			for {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				p, err := itr.Next()
+				if err != nil || p == nil {
+					return err
+				}
+				var buf bytes.Buffer
+				if err := influxql.NewPointEncoder(&buf).EncodeIntegerPoint(p); err != nil {
+					return err
+				}
+				if err := transport.WriteFramedBytes(w, buf.Bytes()); err != nil {
+					return err
+				}
+			}
+		}, nil
+	case influxql.StringIterator:
+		return ReadShardCommandResponse_STRING, func(ctx context.Context, w io.Writer) error {
+			for {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				p, err := itr.Next()
+				if err != nil || p == nil {
+					return err
+				}
+				var buf bytes.Buffer
+				if err := influxql.NewPointEncoder(&buf).EncodeStringPoint(p); err != nil {
+					return err
+				}
+				if err := transport.WriteFramedBytes(w, buf.Bytes()); err != nil {
+					return err
+				}
+			}
+		}, nil
+	case influxql.BooleanIterator:
+		return ReadShardCommandResponse_BOOLEAN, func(ctx context.Context, w io.Writer) error {
+			for {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				p, err := itr.Next()
+				if err != nil || p == nil {
+					return err
+				}
+				var buf bytes.Buffer
+				if err := influxql.NewPointEncoder(&buf).EncodeBooleanPoint(p); err != nil {
+					return err
+				}
+				if err := transport.WriteFramedBytes(w, buf.Bytes()); err != nil {
+					return err
+				}
+			}
+		}, nil
+	default:
+		return 0, nil, fmt.Errorf("gossip: unsupported iterator type %T", itr)
+	}
+}
+
+// dispatchFieldDimensions answers a FieldDimensionsCommand against the
+// shard's local IteratorCreator, replying with the single-frame
+// request/response envelope rpcExchange expects rather than ReadShard's
+// streamed frames.
+func (d *StoreDispatcher) dispatchFieldDimensions(conn io.ReadWriter, body []byte) error {
+	cmd := &FieldDimensionsCommand{}
+	if err := proto.Unmarshal(body, cmd); err != nil {
+		return err
+	}
+
+	var sources influxql.Sources
+	if err := sources.UnmarshalBinary(cmd.Sources); err != nil {
+		return err
+	}
+
+	resp := &FieldDimensionsCommandResponse{}
+	ic, err := d.Store.IteratorCreator([]uint64{cmd.ShardID}, nil)
+	if err != nil {
+		resp.Error = err.Error()
+	} else if fields, dimensions, err := ic.FieldDimensions(sources); err != nil {
+		resp.Error = err.Error()
+	} else {
+		resp.Fields = make(map[string]int32, len(fields))
+		for k, v := range fields {
+			resp.Fields[k] = int32(v)
+		}
+		resp.Dimensions = make([]string, 0, len(dimensions))
+		for k := range dimensions {
+			resp.Dimensions = append(resp.Dimensions, k)
+		}
+	}
+
+	respBody, err := proto.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return transport.WriteMessage(conn, transport.CommandFieldDimensions, respBody)
+}
+
+// dispatchExpandSources answers an ExpandSourcesCommand against the
+// shard's local IteratorCreator, replying with the single-frame
+// request/response envelope rpcExchange expects.
+func (d *StoreDispatcher) dispatchExpandSources(conn io.ReadWriter, body []byte) error {
+	cmd := &ExpandSourcesCommand{}
+	if err := proto.Unmarshal(body, cmd); err != nil {
+		return err
+	}
+
+	var sources influxql.Sources
+	if err := sources.UnmarshalBinary(cmd.Sources); err != nil {
+		return err
+	}
+
+	resp := &ExpandSourcesCommandResponse{}
+	ic, err := d.Store.IteratorCreator([]uint64{cmd.ShardID}, nil)
+	if err != nil {
+		resp.Error = err.Error()
+	} else if expanded, err := ic.ExpandSources(sources); err != nil {
+		resp.Error = err.Error()
+	} else if respBinary, err := expanded.MarshalBinary(); err != nil {
+		resp.Error = err.Error()
+	} else {
+		resp.Sources = respBinary
+	}
+
+	respBody, err := proto.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return transport.WriteMessage(conn, transport.CommandExpandSources, respBody)
+}
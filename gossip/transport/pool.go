@@ -0,0 +1,100 @@
+package transport
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// DialTimeout bounds how long Pool.Get will wait to establish a brand new
+// connection to a peer.
+const DialTimeout = 5 * time.Second
+
+// Pool maintains a small set of persistent, health-checked TCP connections
+// per peer address. Callers Get a connection, use it for exactly one
+// request/response exchange, then either Put it back (healthy) or Discard
+// it (the connection misbehaved and should not be reused).
+type Pool struct {
+	mu    sync.Mutex
+	idle  map[string][]net.Conn
+	limit int
+}
+
+// NewPool returns a Pool that keeps up to limit idle connections open per
+// peer address.
+func NewPool(limit int) *Pool {
+	return &Pool{
+		idle:  make(map[string][]net.Conn),
+		limit: limit,
+	}
+}
+
+// Get returns a healthy pooled connection to addr, dialing a new one if
+// none are idle. Connections are health-checked with a one-byte read under a
+// healthProbeDeadline deadline before being handed out so a peer that closed the socket
+// while it sat idle doesn't surface as a confusing mid-request error.
+func (p *Pool) Get(addr string) (net.Conn, error) {
+	p.mu.Lock()
+	conns := p.idle[addr]
+	for len(conns) > 0 {
+		conn := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+		p.idle[addr] = conns
+		p.mu.Unlock()
+
+		if healthy(conn) {
+			return conn, nil
+		}
+		conn.Close()
+
+		p.mu.Lock()
+		conns = p.idle[addr]
+	}
+	p.mu.Unlock()
+
+	return net.DialTimeout("tcp", addr, DialTimeout)
+}
+
+// Put returns conn to the idle pool for addr so a later Get can reuse it.
+func (p *Pool) Put(addr string, conn net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.idle[addr]) >= p.limit {
+		conn.Close()
+		return
+	}
+	p.idle[addr] = append(p.idle[addr], conn)
+}
+
+// Discard closes conn rather than returning it to the pool. Call this when
+// a read or write on conn failed, since the connection's framing state can
+// no longer be trusted.
+func (p *Pool) Discard(conn net.Conn) {
+	conn.Close()
+}
+
+// healthProbeDeadline is the margin given to the health-check read below.
+// A deadline of exactly time.Now() expires before the read ever gets a
+// chance to observe data already sitting in the socket buffer, so a peer
+// that closed its side while idle would misreport as a timeout (healthy)
+// rather than EOF (dead); a small positive margin is enough for the read to
+// see whatever is already there without meaningfully blocking otherwise.
+const healthProbeDeadline = 2 * time.Millisecond
+
+// healthy checks, blocking for at most healthProbeDeadline, that the peer
+// hasn't closed conn while it was idle.
+func healthy(conn net.Conn) bool {
+	if err := conn.SetReadDeadline(time.Now().Add(healthProbeDeadline)); err != nil {
+		return false
+	}
+	var buf [1]byte
+	_, err := conn.Read(buf[:])
+	conn.SetReadDeadline(time.Time{})
+	if err == nil {
+		// Peer sent unexpected data on an idle connection; don't reuse it.
+		return false
+	}
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
@@ -0,0 +1,191 @@
+// Package transport implements a small length-prefixed framing protocol
+// used by gossip cluster RPCs (shard reads, field dimensions, source
+// expansion) so peers can exchange protobuf messages over a persistent
+// TCP connection instead of paying an HTTP handshake on every call.
+package transport
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"time"
+)
+
+// PointChunkSize is the buffer size used when streaming a point-frame
+// sequence off a connection, so a slow reader applies backpressure to the
+// writer one chunk at a time instead of the writer racing ahead into an
+// unbounded buffer.
+const PointChunkSize = 64 * 1024
+
+// NewPointReader wraps conn in a buffered reader sized for streaming a
+// point-frame sequence written by WriteFramedBytes.
+func NewPointReader(r io.Reader) *bufio.Reader {
+	return bufio.NewReaderSize(r, PointChunkSize)
+}
+
+// WriteFramedBytes writes body prefixed by its length as a protobuf-style
+// unsigned varint. A zero-length call acts as the terminator for a
+// sequence of frames: ReadFramedBytes reports it via ok=false rather than
+// treating it as an error, the way ReadMessage's fixed-size envelopes do.
+func WriteFramedBytes(w io.Writer, body []byte) error {
+	var lenBuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuf[:], uint64(len(body)))
+	if _, err := w.Write(lenBuf[:n]); err != nil {
+		return fmt.Errorf("write frame length: %s", err)
+	}
+	if len(body) == 0 {
+		return nil
+	}
+	_, err := w.Write(body)
+	return err
+}
+
+// ReadFramedBytes reads one varint-length-prefixed frame written by
+// WriteFramedBytes. ok is false when the frame read was the zero-length
+// terminator, in which case body is nil and err is nil.
+func ReadFramedBytes(r *bufio.Reader) (body []byte, ok bool, err error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, false, err
+	}
+	if n == 0 {
+		return nil, false, nil
+	}
+	if n > maxBodySize {
+		return nil, false, fmt.Errorf("transport: frame of %d bytes exceeds maximum of %d", n, maxBodySize)
+	}
+
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, false, err
+	}
+	return buf, true, nil
+}
+
+// Command identifies the kind of request carried in a frame envelope.
+type Command uint8
+
+// Supported commands.
+const (
+	CommandReadShard Command = iota + 1
+	CommandFieldDimensions
+	CommandExpandSources
+)
+
+// maxBodySize guards against a corrupt or malicious length prefix causing
+// an unbounded allocation.
+const maxBodySize = 128 << 20 // 128MB
+
+// Envelope is the small header that precedes every message body: a command
+// type followed by the protobuf-encoded payload for that command.
+type Envelope struct {
+	Command Command
+	Body    []byte
+}
+
+// WriteMessage writes cmd and body to w as a single frame: a little-endian
+// uint32 length (covering the command byte plus body), the command byte,
+// then the body itself.
+func WriteMessage(w io.Writer, cmd Command, body []byte) error {
+	var lenBuf [4]byte
+	binary.LittleEndian.PutUint32(lenBuf[:], uint32(len(body)+1))
+	if _, err := w.Write(lenBuf[:]); err != nil {
+		return fmt.Errorf("write frame length: %s", err)
+	}
+	if _, err := w.Write([]byte{byte(cmd)}); err != nil {
+		return fmt.Errorf("write frame command: %s", err)
+	}
+	if _, err := w.Write(body); err != nil {
+		return fmt.Errorf("write frame body: %s", err)
+	}
+	return nil
+}
+
+// ReadMessage reads a single frame written by WriteMessage. It uses
+// io.ReadFull against the length prefix and then against exactly that many
+// body bytes, so callers never need to buffer more than one frame.
+func ReadMessage(r io.Reader) (Envelope, error) {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return Envelope{}, err
+	}
+	n := binary.LittleEndian.Uint32(lenBuf[:])
+	if n == 0 {
+		return Envelope{}, fmt.Errorf("transport: empty frame")
+	}
+	if n > maxBodySize {
+		return Envelope{}, fmt.Errorf("transport: frame of %d bytes exceeds maximum of %d", n, maxBodySize)
+	}
+
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return Envelope{}, err
+	}
+	return Envelope{Command: Command(buf[0]), Body: buf[1:]}, nil
+}
+
+// Dispatcher routes a decoded command to the handler responsible for
+// serving it and writes the response back to conn. Implementations live on
+// the server side, one per command type that can arrive over a pooled
+// connection. conn is the raw net.Conn, not just an io.ReadWriter, so a
+// long-running handler (a shard scan streaming many point frames) can set
+// its own deadlines and watch for the peer closing its side mid-response.
+type Dispatcher interface {
+	Dispatch(conn net.Conn, env Envelope) error
+}
+
+// RequestReadTimeout bounds how long serveConn will wait for a peer to send
+// the next request frame on an otherwise-idle pooled connection.
+const RequestReadTimeout = 30 * time.Second
+
+// ListenAndServe is the server-side counterpart of Pool: it accepts TCP
+// connections on addr and, for each one, reads frames written by
+// WriteMessage and routes them to d until the connection is closed or
+// sends a malformed frame. It returns once the listener is bound; serving
+// continues in the background until the returned listener is closed.
+func ListenAndServe(addr string, d Dispatcher) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return // listener closed
+			}
+			go serveConn(conn, d)
+		}
+	}()
+
+	return ln, nil
+}
+
+// serveConn dispatches every message conn sends, one at a time, until
+// ReadMessage fails (the peer closed the connection or sent a malformed
+// frame) or Dispatch returns an error.
+func serveConn(conn net.Conn, d Dispatcher) {
+	defer conn.Close()
+	for {
+		conn.SetReadDeadline(time.Now().Add(RequestReadTimeout))
+		env, err := ReadMessage(conn)
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("transport: reading request: %s", err)
+			}
+			return
+		}
+		// Dispatch's handlers may run far longer than RequestReadTimeout
+		// (a shard scan streaming many point frames); they're responsible
+		// for their own deadlines on conn rather than inheriting this one.
+		conn.SetReadDeadline(time.Time{})
+		if err := d.Dispatch(conn, env); err != nil {
+			log.Printf("transport: dispatching command %d: %s", env.Command, err)
+			return
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestWriteReadFramedBytes_RoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	frames := [][]byte{[]byte("a"), []byte("bb"), []byte("ccc")}
+	for _, f := range frames {
+		if err := WriteFramedBytes(&buf, f); err != nil {
+			t.Fatalf("WriteFramedBytes: %s", err)
+		}
+	}
+	if err := WriteFramedBytes(&buf, nil); err != nil {
+		t.Fatalf("WriteFramedBytes terminator: %s", err)
+	}
+
+	r := bufio.NewReader(&buf)
+	for i, want := range frames {
+		body, ok, err := ReadFramedBytes(r)
+		if err != nil || !ok {
+			t.Fatalf("frame %d: ReadFramedBytes returned ok=%v err=%v", i, ok, err)
+		}
+		if !bytes.Equal(body, want) {
+			t.Fatalf("frame %d: got %q, want %q", i, body, want)
+		}
+	}
+
+	body, ok, err := ReadFramedBytes(r)
+	if err != nil {
+		t.Fatalf("terminator frame: unexpected error %s", err)
+	}
+	if ok || body != nil {
+		t.Fatalf("terminator frame should report ok=false, body=nil; got ok=%v body=%v", ok, body)
+	}
+}
+
+func TestReadFramedBytes_RejectsOversizedFrame(t *testing.T) {
+	var buf bytes.Buffer
+	lenBuf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(lenBuf, maxBodySize+1)
+	buf.Write(lenBuf[:n])
+
+	if _, _, err := ReadFramedBytes(bufio.NewReader(&buf)); err == nil {
+		t.Fatal("expected an error for a frame exceeding maxBodySize")
+	}
+}
+
+func TestWriteReadMessage_RoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	body := []byte("payload")
+	if err := WriteMessage(&buf, CommandReadShard, body); err != nil {
+		t.Fatalf("WriteMessage: %s", err)
+	}
+
+	env, err := ReadMessage(&buf)
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	if env.Command != CommandReadShard {
+		t.Fatalf("got command %d, want %d", env.Command, CommandReadShard)
+	}
+	if !bytes.Equal(env.Body, body) {
+		t.Fatalf("got body %q, want %q", env.Body, body)
+	}
+}
+
+func TestReadMessage_RejectsEmptyFrame(t *testing.T) {
+	var buf bytes.Buffer
+	var lenBuf [4]byte
+	binary.LittleEndian.PutUint32(lenBuf[:], 0)
+	buf.Write(lenBuf[:])
+
+	if _, err := ReadMessage(&buf); err == nil {
+		t.Fatal("expected an error for a zero-length message frame")
+	}
+}
+
+func TestPool_PutThenGetReusesConnection(t *testing.T) {
+	p := NewPool(4)
+	a, b := net.Pipe()
+	defer b.Close()
+
+	p.Put("peer", a)
+
+	got, err := p.Get("peer")
+	if err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+	if got != a {
+		t.Fatal("Get should have returned the connection just Put into the pool")
+	}
+}
+
+func TestPool_DiscardClosesConnection(t *testing.T) {
+	p := NewPool(4)
+	a, b := net.Pipe()
+	defer b.Close()
+
+	p.Discard(a)
+
+	if _, err := a.Write([]byte("x")); err == nil {
+		t.Fatal("expected a write on a discarded connection to fail")
+	}
+}
+
+func TestPool_PutRespectsLimit(t *testing.T) {
+	p := NewPool(1)
+	a, closeA := net.Pipe()
+	b, closeB := net.Pipe()
+	defer closeA.Close()
+	defer closeB.Close()
+
+	p.Put("peer", a)
+	p.Put("peer", b) // over the limit of 1; should be closed rather than queued
+
+	if _, err := b.Write([]byte("x")); err == nil {
+		t.Fatal("expected the connection over the pool's limit to have been closed")
+	}
+
+	got, err := p.Get("peer")
+	if err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+	if got != a {
+		t.Fatal("Get should have returned the one connection that fit under the limit")
+	}
+}
+
+func TestPool_GetDialsWhenIdleEmpty(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %s", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(io.Discard, conn)
+	}()
+
+	p := NewPool(4)
+	conn, err := p.Get(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+	defer conn.Close()
+}
+
+func TestPool_GetSkipsDeadIdleConnection(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %s", err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		accepted <- conn
+	}()
+
+	p := NewPool(4)
+	first, err := p.Get(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Get (dial): %s", err)
+	}
+
+	peer := <-accepted
+	peer.Close() // the remote side goes away while first sits idle in the pool
+
+	p.Put(ln.Addr().String(), first)
+	time.Sleep(20 * time.Millisecond) // give the close a moment to land
+
+	second, err := p.Get(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Get (after dead idle conn): %s", err)
+	}
+	defer second.Close()
+
+	if second == first {
+		t.Fatal("Get should not have returned the dead idle connection")
+	}
+}